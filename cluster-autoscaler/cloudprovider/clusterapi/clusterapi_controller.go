@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/scale"
+	"k8s.io/client-go/tools/cache"
+)
+
+// machineController watches the management cluster for MachineSets and
+// MachineDeployments, keeping informer caches up to date so that the
+// provider can answer NodeGroup queries without hitting the API server on
+// every call.
+type machineController struct {
+	machineSetInformer        dynamicinformer.GenericInformer
+	machineDeploymentInformer dynamicinformer.GenericInformer
+	clusterInformer           dynamicinformer.GenericInformer
+
+	managementClient      dynamic.Interface
+	managementScaleClient scale.ScalesGetter
+
+	machineSetResource        schema.GroupVersionResource
+	machineDeploymentResource schema.GroupVersionResource
+	clusterResource           schema.GroupVersionResource
+}
+
+// run starts the informers backing the controller and blocks until their
+// caches have synced or the stop channel is closed.
+func (c *machineController) run(stopCh <-chan struct{}) error {
+	go c.machineSetInformer.Informer().Run(stopCh)
+	go c.machineDeploymentInformer.Informer().Run(stopCh)
+	if c.clusterInformer != nil {
+		go c.clusterInformer.Informer().Run(stopCh)
+	}
+
+	syncFuncs := []cache.InformerSynced{
+		c.machineSetInformer.Informer().HasSynced,
+		c.machineDeploymentInformer.Informer().HasSynced,
+	}
+	if c.clusterInformer != nil {
+		syncFuncs = append(syncFuncs, c.clusterInformer.Informer().HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(stopCh, syncFuncs...) {
+		return fmt.Errorf("syncing informer caches")
+	}
+
+	return nil
+}
+
+// findCluster looks up the Cluster owning a MachineSet/MachineDeployment by
+// name, returning (nil, nil) if no Cluster informer is configured or the
+// Cluster can't be found, since not every caller can treat a missing
+// Cluster as fatal.
+func (c *machineController) findCluster(namespace, name string) (*unstructured.Unstructured, error) {
+	if c.clusterInformer == nil {
+		return nil, nil
+	}
+
+	obj, err := c.clusterInformer.Lister().ByNamespace(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.(*unstructured.Unstructured), nil
+}
+
+// findScalableResource looks up a MachineSet or MachineDeployment by
+// namespace and name, preferring the MachineSet informer since
+// MachineDeployments are resolved to their owned MachineSet in practice.
+func (c *machineController) findScalableResource(namespace, name string) (*unstructured.Unstructured, error) {
+	obj, err := c.machineSetInformer.Lister().ByNamespace(namespace).Get(name)
+	if err == nil {
+		return obj.(*unstructured.Unstructured), nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	obj, err = c.machineDeploymentInformer.Lister().ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.(*unstructured.Unstructured), nil
+}
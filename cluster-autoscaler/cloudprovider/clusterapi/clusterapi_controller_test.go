@@ -0,0 +1,270 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/scale"
+)
+
+var (
+	machineSetGVR        = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "machinesets"}
+	machineDeploymentGVR = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "machinedeployments"}
+	clusterGVR           = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "clusters"}
+)
+
+// testConfig bundles the unstructured objects a single test scenario needs:
+// exactly one of machineSet or machineDeployment is populated. cluster is
+// optional and only needed by tests exercising PreflightCheck.
+type testConfig struct {
+	clusterName       string
+	namespace         string
+	machineSet        *unstructured.Unstructured
+	machineDeployment *unstructured.Unstructured
+	cluster           *unstructured.Unstructured
+}
+
+// withCluster attaches a Cluster object at the given control plane version
+// to testConfig, for use by tests exercising PreflightCheck.
+func (c *testConfig) withCluster(controlPlaneVersion string) *testConfig {
+	c.cluster = buildTestCluster(c.namespace, c.clusterName, controlPlaneVersion)
+	return c
+}
+
+func buildTestCluster(namespace, name, controlPlaneVersion string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": clusterGVR.GroupVersion().String(),
+			"kind":       "Cluster",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+			},
+			"status": map[string]interface{}{
+				"version": controlPlaneVersion,
+			},
+		},
+	}
+}
+
+// RandomString returns a random lowercase alphanumeric string of length n,
+// used to keep test fixtures from colliding between subtests.
+func RandomString(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[r.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+func buildTestMachineSet(clusterName, namespace, name string, replicas int, annotations map[string]string) *unstructured.Unstructured {
+	annotationsObj := map[string]interface{}{}
+	for k, v := range annotations {
+		annotationsObj[k] = v
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": machineSetGVR.GroupVersion().String(),
+			"kind":       "MachineSet",
+			"metadata": map[string]interface{}{
+				"name":        name,
+				"namespace":   namespace,
+				"annotations": annotationsObj,
+				"labels": map[string]interface{}{
+					"cluster.x-k8s.io/cluster-name": clusterName,
+				},
+			},
+			"spec": map[string]interface{}{
+				"replicas": int64(replicas),
+			},
+		},
+	}
+}
+
+func buildTestMachineDeployment(clusterName, namespace, name string, replicas int, annotations map[string]string) *unstructured.Unstructured {
+	u := buildTestMachineSet(clusterName, namespace, name, replicas, annotations)
+	u.SetKind("MachineDeployment")
+	u.SetAPIVersion(machineDeploymentGVR.GroupVersion().String())
+	return u
+}
+
+// withMachineVersion stamps spec.template.spec.version onto the
+// MachineSet/MachineDeployment in testConfig, for use by tests exercising
+// PreflightCheck's control plane version skew logic.
+func (c *testConfig) withMachineVersion(version string) *testConfig {
+	u := c.machineSet
+	if c.machineDeployment != nil {
+		u = c.machineDeployment
+	}
+	if err := unstructured.SetNestedField(u.Object, version, "spec", "template", "spec", "version"); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func createMachineSetTestConfig(clusterName, namespace, name string, replicas int, annotations map[string]string) *testConfig {
+	return &testConfig{
+		clusterName: clusterName,
+		namespace:   namespace,
+		machineSet:  buildTestMachineSet(clusterName, namespace, name, replicas, annotations),
+	}
+}
+
+func createMachineDeploymentTestConfig(clusterName, namespace, name string, replicas int, annotations map[string]string) *testConfig {
+	return &testConfig{
+		clusterName:       clusterName,
+		namespace:         namespace,
+		machineDeployment: buildTestMachineDeployment(clusterName, namespace, name, replicas, annotations),
+	}
+}
+
+// fakeScaleClient implements scale.ScalesGetter on top of the dynamic fake
+// client so the Scale Get/Update calls made by SetSize and the tests
+// round-trip through the same object store the informers watch, without pulling in a second, independently seeded fake.
+type fakeScaleClient struct {
+	dynamicClient dynamic.Interface
+	resourceByGR  map[schema.GroupResource]schema.GroupVersionResource
+}
+
+type fakeScaleInterface struct {
+	client    *fakeScaleClient
+	namespace string
+}
+
+func (f *fakeScaleClient) Scales(namespace string) scale.ScaleInterface {
+	return &fakeScaleInterface{client: f, namespace: namespace}
+}
+
+func (f *fakeScaleInterface) Get(ctx context.Context, resource schema.GroupResource, name string, opts metav1.GetOptions) (*autoscalingv1.Scale, error) {
+	gvr, ok := f.client.resourceByGR[resource]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %v", resource)
+	}
+
+	u, err := f.client.dynamicClient.Resource(gvr).Namespace(f.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	replicas, _, err := unstructured.NestedInt64(u.Object, "spec", "replicas")
+	if err != nil {
+		return nil, err
+	}
+
+	return &autoscalingv1.Scale{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: f.namespace, Generation: u.GetGeneration()},
+		Spec:       autoscalingv1.ScaleSpec{Replicas: int32(replicas)},
+	}, nil
+}
+
+func (f *fakeScaleInterface) Update(ctx context.Context, resource schema.GroupResource, s *autoscalingv1.Scale, opts metav1.UpdateOptions) (*autoscalingv1.Scale, error) {
+	gvr, ok := f.client.resourceByGR[resource]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %v", resource)
+	}
+
+	u, err := f.client.dynamicClient.Resource(gvr).Namespace(f.namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unstructured.SetNestedField(u.Object, int64(s.Spec.Replicas), "spec", "replicas"); err != nil {
+		return nil, err
+	}
+
+	updated, err := f.client.dynamicClient.Resource(gvr).Namespace(f.namespace).Update(ctx, u, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &autoscalingv1.Scale{
+		ObjectMeta: metav1.ObjectMeta{Name: s.Name, Namespace: f.namespace, Generation: updated.GetGeneration()},
+		Spec:       s.Spec,
+	}, nil
+}
+
+func (f *fakeScaleInterface) Patch(ctx context.Context, gvr schema.GroupVersionResource, name string, pt types.PatchType, patch []byte, opts metav1.PatchOptions) (*autoscalingv1.Scale, error) {
+	return nil, fmt.Errorf("patch not supported by fakeScaleClient")
+}
+
+// mustCreateTestController wires up a machineController backed by a fake
+// dynamic client seeded with the objects in testConfig, failing the test
+// immediately on any setup error.
+func mustCreateTestController(t *testing.T, testConfig *testConfig) (*machineController, func()) {
+	t.Helper()
+
+	var objects []runtime.Object
+	if testConfig.machineSet != nil {
+		objects = append(objects, testConfig.machineSet)
+	}
+	if testConfig.machineDeployment != nil {
+		objects = append(objects, testConfig.machineDeployment)
+	}
+	if testConfig.cluster != nil {
+		objects = append(objects, testConfig.cluster)
+	}
+
+	listKinds := map[schema.GroupVersionResource]string{
+		machineSetGVR:        "MachineSetList",
+		machineDeploymentGVR: "MachineDeploymentList",
+		clusterGVR:           "ClusterList",
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+
+	controller := &machineController{
+		machineSetInformer:        factory.ForResource(machineSetGVR),
+		machineDeploymentInformer: factory.ForResource(machineDeploymentGVR),
+		clusterInformer:           factory.ForResource(clusterGVR),
+		managementClient:          dynamicClient,
+		machineSetResource:        machineSetGVR,
+		machineDeploymentResource: machineDeploymentGVR,
+		clusterResource:           clusterGVR,
+		managementScaleClient: &fakeScaleClient{
+			dynamicClient: dynamicClient,
+			resourceByGR: map[schema.GroupResource]schema.GroupVersionResource{
+				machineSetGVR.GroupResource():        machineSetGVR,
+				machineDeploymentGVR.GroupResource(): machineDeploymentGVR,
+			},
+		},
+	}
+
+	stopCh := make(chan struct{})
+	if err := controller.run(stopCh); err != nil {
+		t.Fatalf("failed to start test controller: %v", err)
+	}
+
+	return controller, func() { close(stopCh) }
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// skipPreflightChecksAnnotation lets a MachineSet/MachineDeployment opt out
+// of PreflightCheck, mirroring the escape hatch Cluster API's own
+// controllers offer for the same checks.
+const skipPreflightChecksAnnotation = "cluster.x-k8s.io/skip-preflight-checks"
+
+// clusterNameLabel is the well-known label Cluster API stamps onto every
+// object that belongs to a particular Cluster.
+const clusterNameLabel = "cluster.x-k8s.io/cluster-name"
+
+// controlPlaneUpgradingCheck is the name reported in a PreflightCheckError
+// when a scale-up is rejected because the new Machines' version would
+// violate kubeadm's supported version skew against the owning Cluster's
+// control plane version, or when that comparison can't be made.
+const controlPlaneUpgradingCheck = "ControlPlaneIsStable"
+
+// PreflightCheckError is returned by PreflightCheck when a named check
+// fails, so that callers can distinguish a rejected scale-up from a
+// generic error talking to the API server and emit a more specific event.
+type PreflightCheckError struct {
+	// CheckName identifies which preflight check failed.
+	CheckName string
+	// Reason is a human readable explanation of the failure.
+	Reason string
+}
+
+func (e *PreflightCheckError) Error() string {
+	return fmt.Sprintf("preflight check %q failed: %s", e.CheckName, e.Reason)
+}
+
+func newPreflightCheckError(name, format string, args ...interface{}) *PreflightCheckError {
+	return &PreflightCheckError{CheckName: name, Reason: fmt.Sprintf(format, args...)}
+}
+
+// PreflightCheck runs the checks that Cluster API's MachineSet controller
+// would otherwise enforce at apply time, so the autoscaler doesn't drive a
+// scale-up that the management cluster is going to refuse. Scale-down is
+// always allowed: removing Machines can't violate version skew.
+func (r unstructuredScalableResource) PreflightCheck(delta int) error {
+	if delta <= 0 {
+		return nil
+	}
+
+	if skipPreflightChecks(r.unstructured.GetAnnotations()) {
+		return nil
+	}
+
+	return r.preflightCheckControlPlaneStable()
+}
+
+func skipPreflightChecks(annotations map[string]string) bool {
+	v, found := annotations[skipPreflightChecksAnnotation]
+	return found && v == "true"
+}
+
+// preflightCheckControlPlaneStable rejects a scale-up when the MachineSet's
+// Machine version falls outside the +0/-3 minor version skew kubeadm
+// enforces against the Cluster's control plane version (as returned by
+// clusterControlPlaneVersion). It does not compare the Cluster's
+// status.version with its spec.topology.version, so an upgrade in progress
+// is only caught when it puts the two versions out of that skew.
+func (r unstructuredScalableResource) preflightCheckControlPlaneStable() error {
+	clusterName, found := r.unstructured.GetLabels()[clusterNameLabel]
+	if !found {
+		return nil
+	}
+
+	cluster, err := r.controller.findCluster(r.Namespace(), clusterName)
+	if err != nil {
+		return newPreflightCheckError(controlPlaneUpgradingCheck, "reading Cluster %s/%s: %v", r.Namespace(), clusterName, err)
+	}
+	if cluster == nil {
+		return nil
+	}
+
+	controlPlaneVersion, err := clusterControlPlaneVersion(cluster)
+	if err != nil || controlPlaneVersion == "" {
+		return nil
+	}
+
+	machineVersion, found, err := unstructured.NestedString(r.unstructured.Object, "spec", "template", "spec", "version")
+	if err != nil || !found || machineVersion == "" {
+		return nil
+	}
+
+	skew, err := kubeadmMinorVersionSkew(controlPlaneVersion, machineVersion)
+	if err != nil {
+		return newPreflightCheckError(controlPlaneUpgradingCheck, "%v", err)
+	}
+
+	if skew < 0 || skew > 3 {
+		return newPreflightCheckError(controlPlaneUpgradingCheck,
+			"scaling up would create Machines at version %s while the control plane is at %s (skew %d, kubeadm supports +0/-3)",
+			machineVersion, controlPlaneVersion, skew)
+	}
+
+	return nil
+}
+
+// clusterControlPlaneVersion returns the version the control plane is
+// currently at, preferring the observed status.version and falling back to
+// the desired spec.topology.version for clusters still converging on a
+// brand new topology.
+func clusterControlPlaneVersion(cluster *unstructured.Unstructured) (string, error) {
+	if v, found, err := unstructured.NestedString(cluster.Object, "status", "version"); err != nil {
+		return "", err
+	} else if found && v != "" {
+		return v, nil
+	}
+
+	v, _, err := unstructured.NestedString(cluster.Object, "spec", "topology", "version")
+	if err != nil {
+		return "", err
+	}
+
+	return v, nil
+}
+
+// kubeadmMinorVersionSkew returns controlPlaneMinor - machineMinor, erroring
+// out if the two versions don't share a major version or don't parse as
+// semantic versions.
+func kubeadmMinorVersionSkew(controlPlaneVersion, machineVersion string) (int, error) {
+	cpMajor, cpMinor, err := majorMinor(controlPlaneVersion)
+	if err != nil {
+		return 0, fmt.Errorf("parsing control plane version %q: %v", controlPlaneVersion, err)
+	}
+
+	mMajor, mMinor, err := majorMinor(machineVersion)
+	if err != nil {
+		return 0, fmt.Errorf("parsing machine version %q: %v", machineVersion, err)
+	}
+
+	if cpMajor != mMajor {
+		return 0, fmt.Errorf("major version mismatch between control plane (%s) and machine (%s)", controlPlaneVersion, machineVersion)
+	}
+
+	return cpMinor - mMinor, nil
+}
+
+func majorMinor(version string) (int, int, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("expected a semantic version, got %q", version)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid major version in %q: %v", version, err)
+	}
+
+	minor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid minor version in %q: %v", version, err)
+	}
+
+	return major, minor, nil
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+// scaleWaitTimeout is the controller-level default for how long
+// SetSizeAndWait will wait for a scaled MachineSet/MachineDeployment to
+// become ready before giving up, overridable per call through
+// SetSizeAndWaitOptions.
+var scaleWaitTimeout = flag.Duration("scale-wait-timeout", 10*time.Minute,
+	"How long SetSizeAndWait waits for a scaled MachineSet/MachineDeployment to become ready before returning a ScaleNotReadyError.")
+
+// clock abstracts the passage of time so tests can exercise the timeout
+// path of SetSizeAndWait deterministically instead of sleeping for real.
+type clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// waitClock is swapped out by tests that need a fake clock; production
+// code always goes through realClock.
+var waitClock clock = realClock{}
+
+// ReadyChecker reports whether an object of a given Kind has converged to
+// its desired replica count. It is modeled after Helm 3.5's resource-status
+// checker: per-kind implementations are kept in a registry keyed by Kind.
+// SetSizeAndWait consults only the checker for the scaled resource's own
+// Kind and only observes objects through the MachineSet and
+// MachineDeployment informers, so a checker registered for any other kind
+// (a MachinePool, or the Machines backing a MachineSet) is not used by it.
+type ReadyChecker interface {
+	// Kind returns the API kind this checker evaluates readiness for.
+	Kind() string
+	// IsReady reports whether u has converged on target replicas. target
+	// is the caller's requested size, not whatever u.spec.replicas happens
+	// to hold, since a cache read racing the scale-up's own Update can
+	// still return the pre-scale object.
+	IsReady(u *unstructured.Unstructured, target int) (bool, error)
+}
+
+// replicaStatusReadyChecker implements ReadyChecker for any resource that
+// exposes spec.replicas alongside status.replicas, status.readyReplicas,
+// status.availableReplicas and status.observedGeneration, which covers
+// both MachineSet and MachineDeployment.
+type replicaStatusReadyChecker struct {
+	kind string
+}
+
+func (c replicaStatusReadyChecker) Kind() string {
+	return c.kind
+}
+
+func (c replicaStatusReadyChecker) IsReady(u *unstructured.Unstructured, target int) (bool, error) {
+	specReplicas, found, err := unstructured.NestedInt64(u.Object, "spec", "replicas")
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, fmt.Errorf("spec.replicas not found for %s %s/%s", u.GetKind(), u.GetNamespace(), u.GetName())
+	}
+	if specReplicas != int64(target) {
+		// u predates (or postdates) the scale we're waiting on; its status
+		// can't tell us anything about convergence on target.
+		return false, nil
+	}
+
+	observedGeneration, found, err := unstructured.NestedInt64(u.Object, "status", "observedGeneration")
+	if err != nil {
+		return false, err
+	}
+	if !found || observedGeneration < u.GetGeneration() {
+		return false, nil
+	}
+
+	for _, field := range []string{"replicas", "readyReplicas", "availableReplicas"} {
+		v, found, err := unstructured.NestedInt64(u.Object, "status", field)
+		if err != nil {
+			return false, err
+		}
+		if !found || v != int64(target) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// readyCheckers is the registry of ReadyChecker implementations consulted
+// by SetSizeAndWait, keyed by Kind.
+var readyCheckers = map[string]ReadyChecker{
+	"MachineSet":        replicaStatusReadyChecker{kind: "MachineSet"},
+	"MachineDeployment": replicaStatusReadyChecker{kind: "MachineDeployment"},
+}
+
+// RegisterReadyChecker adds or replaces the ReadyChecker used for c.Kind().
+// Registering a checker for MachineSet or MachineDeployment replaces the
+// built-in one; checkers for other kinds are stored but never reached by
+// SetSizeAndWait.
+func RegisterReadyChecker(c ReadyChecker) {
+	readyCheckers[c.Kind()] = c
+}
+
+// ScaleNotReadyError is returned by SetSizeAndWait when the scaled resource
+// hasn't converged to its desired replica count before the wait timeout
+// elapses. LastObserved is the most recently observed copy of the resource,
+// or nil if none was ever seen.
+type ScaleNotReadyError struct {
+	Kind         string
+	Namespace    string
+	Name         string
+	LastObserved *unstructured.Unstructured
+}
+
+func (e *ScaleNotReadyError) Error() string {
+	return fmt.Sprintf("timed out waiting for %s %s/%s to become ready", e.Kind, e.Namespace, e.Name)
+}
+
+// SetSizeAndWaitOptions configures SetSizeAndWait. A zero value Timeout
+// falls back to the --scale-wait-timeout flag.
+type SetSizeAndWaitOptions struct {
+	Timeout time.Duration
+}
+
+// SetSizeAndWait behaves like SetSize but doesn't return until the
+// resource's status reports the target replica count as ready and
+// available at the latest observed generation, until the wait times out,
+// or until ctx is done, in which case it returns ctx.Err(). It relies on the existing machineSetInformer/machineDeploymentInformer
+// caches rather than polling the API server directly.
+func (r unstructuredScalableResource) SetSizeAndWait(ctx context.Context, nreplicas int, opts SetSizeAndWaitOptions) error {
+	if err := r.SetSize(nreplicas); err != nil {
+		return err
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = *scaleWaitTimeout
+	}
+
+	return r.waitUntilReady(ctx, nreplicas, timeout)
+}
+
+func (r unstructuredScalableResource) informer() cache.SharedIndexInformer {
+	if r.Kind() == "MachineDeployment" {
+		return r.controller.machineDeploymentInformer.Informer()
+	}
+	return r.controller.machineSetInformer.Informer()
+}
+
+func (r unstructuredScalableResource) waitUntilReady(ctx context.Context, target int, timeout time.Duration) error {
+	checker, ok := readyCheckers[r.Kind()]
+	if !ok {
+		return fmt.Errorf("no ReadyChecker registered for kind %q", r.Kind())
+	}
+
+	done := make(chan error, 1)
+	notify := func(obj interface{}) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok || u.GetNamespace() != r.Namespace() || u.GetName() != r.Name() {
+			return
+		}
+
+		ready, err := checker.IsReady(u, target)
+		if err != nil {
+			select {
+			case done <- err:
+			default:
+			}
+			return
+		}
+		if ready {
+			select {
+			case done <- nil:
+			default:
+			}
+		}
+	}
+
+	informer := r.informer()
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(oldObj, newObj interface{}) { notify(newObj) },
+	})
+	if err != nil {
+		return err
+	}
+	defer informer.RemoveEventHandler(registration)
+
+	if current, err := r.controller.findScalableResource(r.Namespace(), r.Name()); err == nil && current != nil {
+		notify(current)
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-waitClock.After(timeout):
+		last, _ := r.controller.findScalableResource(r.Namespace(), r.Name())
+		return &ScaleNotReadyError{Kind: r.Kind(), Namespace: r.Namespace(), Name: r.Name(), LastObserved: last}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// fakeWaitClock lets tests control exactly when SetSizeAndWait's timeout
+// fires instead of racing against real time.
+type fakeWaitClock struct {
+	ch chan time.Time
+}
+
+func newFakeWaitClock() *fakeWaitClock {
+	return &fakeWaitClock{ch: make(chan time.Time)}
+}
+
+func (f *fakeWaitClock) After(d time.Duration) <-chan time.Time {
+	return f.ch
+}
+
+func (f *fakeWaitClock) fire() {
+	f.ch <- time.Time{}
+}
+
+func withFakeWaitClock(t *testing.T) *fakeWaitClock {
+	t.Helper()
+	fc := newFakeWaitClock()
+	old := waitClock
+	waitClock = fc
+	t.Cleanup(func() { waitClock = old })
+	return fc
+}
+
+// waitForSpecReplicas blocks until the informer observes spec.replicas ==
+// target for the named resource. A test that reads, mutates, and writes the
+// same fake object concurrently with a SetSize call (e.g. to mark it ready)
+// must wait for SetSize's Update to land first, or the two read-modify-write
+// sequences race and whichever Update commits second silently clobbers the
+// other's change.
+func waitForSpecReplicas(t *testing.T, controller *machineController, gvr schema.GroupVersionResource, namespace, name string, target int) {
+	t.Helper()
+
+	matches := func(obj interface{}) bool {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok || u.GetNamespace() != namespace || u.GetName() != name {
+			return false
+		}
+		replicas, found, err := unstructured.NestedInt64(u.Object, "spec", "replicas")
+		return err == nil && found && replicas == int64(target)
+	}
+
+	informer := controller.machineSetInformer.Informer()
+	if gvr == machineDeploymentGVR {
+		informer = controller.machineDeploymentInformer.Informer()
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+	notify := func(obj interface{}) {
+		if matches(obj) {
+			once.Do(func() { close(done) })
+		}
+	}
+
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, newObj interface{}) { notify(newObj) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer informer.RemoveEventHandler(registration)
+
+	if u, err := controller.managementClient.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{}); err == nil {
+		notify(u)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s/%s spec.replicas to reach %d", namespace, name, target)
+	}
+}
+
+// markReady patches status.replicas/readyReplicas/availableReplicas and
+// status.observedGeneration on the named resource so that a
+// replicaStatusReadyChecker reports it ready.
+func markReady(t *testing.T, controller *machineController, gvr schema.GroupVersionResource, namespace, name string, replicas int) {
+	t.Helper()
+
+	u, err := controller.managementClient.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := map[string]interface{}{
+		"replicas":           int64(replicas),
+		"readyReplicas":      int64(replicas),
+		"availableReplicas":  int64(replicas),
+		"observedGeneration": u.GetGeneration(),
+	}
+	if err := unstructured.SetNestedMap(u.Object, status, "status"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := controller.managementClient.Resource(gvr).Namespace(namespace).Update(context.TODO(), u, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetSizeAndWait(t *testing.T) {
+	initialReplicas := 1
+	updatedReplicas := 5
+
+	newTestResource := func(testConfig *testConfig) (*unstructured.Unstructured, schema.GroupVersionResource) {
+		if testConfig.machineDeployment != nil {
+			return testConfig.machineDeployment, machineDeploymentGVR
+		}
+		return testConfig.machineSet, machineSetGVR
+	}
+
+	t.Run("returns once the resource reports ready", func(t *testing.T) {
+		withFakeWaitClock(t)
+
+		testConfig := createMachineSetTestConfig(RandomString(6), RandomString(6), RandomString(6), initialReplicas, nil)
+		controller, stop := mustCreateTestController(t, testConfig)
+		defer stop()
+
+		testResource, gvr := newTestResource(testConfig)
+		sr, err := newUnstructuredScalableResource(controller, testResource)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- sr.SetSizeAndWait(context.Background(), updatedReplicas, SetSizeAndWaitOptions{Timeout: time.Minute})
+		}()
+
+		waitForSpecReplicas(t, controller, gvr, testResource.GetNamespace(), testResource.GetName(), updatedReplicas)
+		markReady(t, controller, gvr, testResource.GetNamespace(), testResource.GetName(), updatedReplicas)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("expected SetSizeAndWait to succeed, got: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for SetSizeAndWait to observe readiness")
+		}
+	})
+
+	t.Run("returns ctx error while stuck pending", func(t *testing.T) {
+		withFakeWaitClock(t)
+
+		testConfig := createMachineSetTestConfig(RandomString(6), RandomString(6), RandomString(6), initialReplicas, nil)
+		controller, stop := mustCreateTestController(t, testConfig)
+		defer stop()
+
+		testResource, _ := newTestResource(testConfig)
+		sr, err := newUnstructuredScalableResource(controller, testResource)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- sr.SetSizeAndWait(ctx, updatedReplicas, SetSizeAndWaitOptions{Timeout: time.Minute})
+		}()
+
+		cancel()
+
+		select {
+		case err := <-done:
+			if err != context.Canceled {
+				t.Fatalf("expected context.Canceled, got: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for SetSizeAndWait to observe ctx cancellation")
+		}
+	})
+
+	t.Run("returns a ScaleNotReadyError on timeout", func(t *testing.T) {
+		fc := withFakeWaitClock(t)
+
+		testConfig := createMachineSetTestConfig(RandomString(6), RandomString(6), RandomString(6), initialReplicas, nil)
+		controller, stop := mustCreateTestController(t, testConfig)
+		defer stop()
+
+		testResource, _ := newTestResource(testConfig)
+		sr, err := newUnstructuredScalableResource(controller, testResource)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- sr.SetSizeAndWait(context.Background(), updatedReplicas, SetSizeAndWaitOptions{Timeout: time.Millisecond})
+		}()
+
+		fc.fire()
+
+		select {
+		case err := <-done:
+			if _, ok := err.(*ScaleNotReadyError); !ok {
+				t.Fatalf("expected a *ScaleNotReadyError, got: %T (%v)", err, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for SetSizeAndWait to report ScaleNotReadyError")
+		}
+	})
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	nodeGroupMinSizeAnnotationKey = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-min-size"
+	nodeGroupMaxSizeAnnotationKey = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-max-size"
+)
+
+// unstructuredScalableResource wraps a MachineSet or MachineDeployment so
+// that it can be driven through the generic scale subresource without the
+// autoscaler core needing to know which kind it is dealing with.
+type unstructuredScalableResource struct {
+	controller   *machineController
+	unstructured *unstructured.Unstructured
+	maxSize      int
+	minSize      int
+}
+
+func newUnstructuredScalableResource(controller *machineController, u *unstructured.Unstructured) (*unstructuredScalableResource, error) {
+	maxSize, minSize, err := parseScalingBounds(u.GetAnnotations())
+	if err != nil {
+		return nil, fmt.Errorf("error validating min/max annotations: %v", err)
+	}
+
+	return &unstructuredScalableResource{
+		controller:   controller,
+		unstructured: u,
+		maxSize:      maxSize,
+		minSize:      minSize,
+	}, nil
+}
+
+func parseScalingBounds(annotations map[string]string) (int, int, error) {
+	maxSize := 0
+	minSize := 0
+
+	if s, found := annotations[nodeGroupMaxSizeAnnotationKey]; found {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %v", nodeGroupMaxSizeAnnotationKey, err)
+		}
+		maxSize = v
+	}
+
+	if s, found := annotations[nodeGroupMinSizeAnnotationKey]; found {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %v", nodeGroupMinSizeAnnotationKey, err)
+		}
+		minSize = v
+	}
+
+	return maxSize, minSize, nil
+}
+
+// Namespace returns the namespace of the underlying resource.
+func (r unstructuredScalableResource) Namespace() string {
+	return r.unstructured.GetNamespace()
+}
+
+// Name returns the name of the underlying resource.
+func (r unstructuredScalableResource) Name() string {
+	return r.unstructured.GetName()
+}
+
+// Kind returns the kind of the underlying resource, e.g. MachineSet.
+func (r unstructuredScalableResource) Kind() string {
+	return r.unstructured.GetKind()
+}
+
+// MaxSize returns the configured maximum size of the scalable resource.
+func (r unstructuredScalableResource) MaxSize() int {
+	return r.maxSize
+}
+
+// MinSize returns the configured minimum size of the scalable resource.
+func (r unstructuredScalableResource) MinSize() int {
+	return r.minSize
+}
+
+// GroupVersionResource returns the GroupVersionResource of the underlying
+// object so that it can be addressed through the scale client.
+func (r unstructuredScalableResource) GroupVersionResource() (schema.GroupVersionResource, error) {
+	gv, err := schema.ParseGroupVersion(r.unstructured.GetAPIVersion())
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+
+	return gv.WithResource(strings.ToLower(r.Kind()) + "s"), nil
+}
+
+// Replicas returns the current value of spec.replicas for the underlying
+// resource.
+func (r unstructuredScalableResource) Replicas() (int, error) {
+	replicas, found, err := unstructured.NestedInt64(r.unstructured.Object, "spec", "replicas")
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("replicas not found for %s %s/%s", r.Kind(), r.Namespace(), r.Name())
+	}
+
+	return int(replicas), nil
+}
+
+// SetSize patches the scale subresource of the underlying MachineSet or
+// MachineDeployment to the requested number of replicas.
+func (r unstructuredScalableResource) SetSize(nreplicas int) error {
+	replicas, err := r.Replicas()
+	if err != nil {
+		return err
+	}
+
+	if err := r.PreflightCheck(nreplicas - replicas); err != nil {
+		return err
+	}
+
+	gvr, err := r.GroupVersionResource()
+	if err != nil {
+		return err
+	}
+
+	scale, err := r.controller.managementScaleClient.Scales(r.Namespace()).
+		Get(context.TODO(), gvr.GroupResource(), r.Name(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get scale for %s %s/%s: %v", r.Kind(), r.Namespace(), r.Name(), err)
+	}
+
+	scale.Spec.Replicas = int32(nreplicas)
+
+	_, err = r.controller.managementScaleClient.Scales(r.Namespace()).
+		Update(context.TODO(), gvr.GroupResource(), scale, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to set size for %s %s/%s: %v", r.Kind(), r.Namespace(), r.Name(), err)
+	}
+
+	return unstructured.SetNestedField(r.unstructured.Object, int64(nreplicas), "spec", "replicas")
+}
@@ -30,7 +30,7 @@ func TestSetSize(t *testing.T) {
 	initialReplicas := 1
 	updatedReplicas := 5
 
-	test := func(t *testing.T, testConfig *testConfig) {
+	test := func(t *testing.T, testConfig *testConfig, wantErr bool) {
 		controller, stop := mustCreateTestController(t, testConfig)
 		defer stop()
 
@@ -50,12 +50,24 @@ func TestSetSize(t *testing.T) {
 		}
 
 		err = sr.SetSize(updatedReplicas)
+		if wantErr {
+			if err == nil {
+				t.Fatal("expected SetSize to fail preflight checks")
+			}
+			if _, ok := err.(*PreflightCheckError); !ok {
+				t.Errorf("expected a *PreflightCheckError, got: %T (%v)", err, err)
+			}
+			return
+		}
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		s, err := sr.controller.managementScaleClient.Scales(testResource.GetNamespace()).
 			Get(context.TODO(), gvr.GroupResource(), testResource.GetName(), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		if s.Spec.Replicas != int32(updatedReplicas) {
 			t.Errorf("expected %v, got: %v", updatedReplicas, s.Spec.Replicas)
@@ -82,7 +94,7 @@ func TestSetSize(t *testing.T) {
 				nodeGroupMinSizeAnnotationKey: "1",
 				nodeGroupMaxSizeAnnotationKey: "10",
 			},
-		))
+		), false)
 	})
 
 	t.Run("MachineDeployment", func(t *testing.T) {
@@ -94,7 +106,56 @@ func TestSetSize(t *testing.T) {
 				nodeGroupMinSizeAnnotationKey: "1",
 				nodeGroupMaxSizeAnnotationKey: "10",
 			},
-		))
+		), false)
+	})
+
+	t.Run("rejects scale-up exceeding kubeadm skew", func(t *testing.T) {
+		clusterName := RandomString(6)
+		namespace := RandomString(6)
+		testConfig := createMachineSetTestConfig(clusterName, namespace, RandomString(6), initialReplicas, nil)
+		testConfig.withCluster("v1.28.0").withMachineVersion("v1.24.0")
+		test(t, testConfig, true)
+	})
+
+	t.Run("rejects scale-up across a major version", func(t *testing.T) {
+		clusterName := RandomString(6)
+		namespace := RandomString(6)
+		testConfig := createMachineSetTestConfig(clusterName, namespace, RandomString(6), initialReplicas, nil)
+		testConfig.withCluster("v2.0.0").withMachineVersion("v1.27.0")
+		test(t, testConfig, true)
+	})
+
+	t.Run("allows scale-up within supported skew", func(t *testing.T) {
+		clusterName := RandomString(6)
+		namespace := RandomString(6)
+		testConfig := createMachineSetTestConfig(clusterName, namespace, RandomString(6), initialReplicas, nil)
+		testConfig.withCluster("v1.28.0").withMachineVersion("v1.26.0")
+		test(t, testConfig, false)
+	})
+
+	t.Run("allows scale-up when skip annotation is set", func(t *testing.T) {
+		clusterName := RandomString(6)
+		namespace := RandomString(6)
+		testConfig := createMachineSetTestConfig(clusterName, namespace, RandomString(6), initialReplicas, map[string]string{
+			skipPreflightChecksAnnotation: "true",
+		})
+		testConfig.withCluster("v1.28.0").withMachineVersion("v1.20.0")
+		test(t, testConfig, false)
+	})
+
+	t.Run("allows scale-down regardless of skew", func(t *testing.T) {
+		clusterName := RandomString(6)
+		namespace := RandomString(6)
+		testConfig := createMachineSetTestConfig(clusterName, namespace, RandomString(6), updatedReplicas+10, nil)
+		testConfig.withCluster("v1.28.0").withMachineVersion("v1.20.0")
+		test(t, testConfig, false)
+	})
+
+	t.Run("allows scale-up when no Cluster object exists", func(t *testing.T) {
+		clusterName := RandomString(6)
+		namespace := RandomString(6)
+		testConfig := createMachineSetTestConfig(clusterName, namespace, RandomString(6), initialReplicas, nil)
+		test(t, testConfig, false)
 	})
 }
 